@@ -0,0 +1,71 @@
+package blob
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileStore is the default Store implementation, backed by a directory on the local filesystem.  This is
+// the same on-disk layout dio has always used for its cache (.dio/<db>/db/<sha256>).
+type fileStore struct {
+	dir string
+}
+
+func newFileStore(dir string) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (f *fileStore) Get(key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(f.dir, key))
+}
+
+func (f *fileStore) Put(key string, r io.Reader) error {
+	tmp, err := ioutil.TempFile(f.dir, key+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err = io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, filepath.Join(f.dir, key))
+}
+
+func (f *fileStore) Stat(key string) (bool, error) {
+	_, err := os.Stat(filepath.Join(f.dir, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (f *fileStore) List(prefix string) (keys []string, err error) {
+	entries, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), prefix) {
+			keys = append(keys, e.Name())
+		}
+	}
+	return keys, nil
+}
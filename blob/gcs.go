@@ -0,0 +1,80 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// gcsStore stores blobs in a Google Cloud Storage bucket, under an optional object prefix.  Credentials are
+// picked up from the standard GOOGLE_APPLICATION_CREDENTIALS environment variable.
+type gcsStore struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+func newGCSStore(bucket, prefix string) (*gcsStore, error) {
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &gcsStore{
+		bucket: client.Bucket(bucket),
+		prefix: strings.Trim(prefix, "/"),
+	}, nil
+}
+
+func (g *gcsStore) objectKey(key string) string {
+	if g.prefix == "" {
+		return key
+	}
+	return g.prefix + "/" + key
+}
+
+func (g *gcsStore) Get(key string) (io.ReadCloser, error) {
+	return g.bucket.Object(g.objectKey(key)).NewReader(context.Background())
+}
+
+func (g *gcsStore) Put(key string, r io.Reader) error {
+	ctx := context.Background()
+	w := g.bucket.Object(g.objectKey(key)).NewWriter(ctx)
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (g *gcsStore) Stat(key string) (bool, error) {
+	_, err := g.bucket.Object(g.objectKey(key)).Attrs(context.Background())
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (g *gcsStore) List(prefix string) (keys []string, err error) {
+	ctx := context.Background()
+	it := g.bucket.Objects(ctx, &storage.Query{Prefix: g.objectKey(prefix)})
+	for {
+		attrs, ierr := it.Next()
+		if ierr == iterator.Done {
+			break
+		}
+		if ierr != nil {
+			return nil, ierr
+		}
+		name := attrs.Name
+		if g.prefix != "" {
+			name = strings.TrimPrefix(name, g.prefix+"/")
+		}
+		keys = append(keys, name)
+	}
+	return keys, nil
+}
@@ -0,0 +1,97 @@
+package blob
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// s3Store stores blobs in an S3 bucket, under an optional key prefix.  Credentials and region are picked up
+// from the standard AWS environment variables / shared config, same as the aws CLI.
+type s3Store struct {
+	bucket string
+	prefix string
+	svc    *s3.S3
+}
+
+func newS3Store(bucket, prefix string) (*s3Store, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, err
+	}
+	return &s3Store{
+		bucket: bucket,
+		prefix: strings.Trim(prefix, "/"),
+		svc:    s3.New(sess),
+	}, nil
+}
+
+func (s *s3Store) objectKey(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+func (s *s3Store) Get(key string) (io.ReadCloser, error) {
+	out, err := s.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *s3Store) Put(key string, r io.Reader) error {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	_, err = s.svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+		Body:   bytes.NewReader(raw),
+	})
+	return err
+}
+
+func (s *s3Store) Stat(key string) (bool, error) {
+	_, err := s.svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	if err != nil {
+		if reqErr, ok := err.(interface{ StatusCode() int }); ok && reqErr.StatusCode() == 404 {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *s3Store) List(prefix string) (keys []string, err error) {
+	err = s.svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.objectKey(prefix)),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			name := aws.StringValue(obj.Key)
+			if s.prefix != "" {
+				name = strings.TrimPrefix(name, s.prefix+"/")
+			}
+			keys = append(keys, name)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
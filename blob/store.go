@@ -0,0 +1,47 @@
+// Package blob provides a small abstraction over where dio's local database cache actually lives, so a
+// team can point it at shared storage (S3, GCS) instead of each workstation keeping its own copy on disk.
+package blob
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// Store is a minimal key/value blob store, keyed by content (normally a database's sha256).  Implementations
+// exist for the local filesystem, S3 and GCS - selected at runtime via a "cache_url" style URL.
+type Store interface {
+	// Get returns a reader for the blob stored under key.  Callers must Close() it.
+	Get(key string) (io.ReadCloser, error)
+
+	// Put stores the contents of r under key, creating or overwriting it.
+	Put(key string, r io.Reader) error
+
+	// Stat reports whether key exists in the store, without fetching its contents.
+	Stat(key string) (bool, error)
+
+	// List returns every key in the store starting with prefix.
+	List(prefix string) ([]string, error)
+}
+
+// NewStore builds a Store from a cache URL such as "file:///home/user/.dio-cache", "s3://bucket/prefix" or
+// "gs://bucket/prefix".
+func NewStore(cacheURL string) (Store, error) {
+	u, err := url.Parse(cacheURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "", "file":
+		// url.Parse treats the first path segment after "//" as the authority (u.Host), not part of
+		// u.Path - so a relative "file://.dio/somedb/db" ends up with Host=".dio", Path="/somedb/db"
+		// rather than the whole thing in Path.  Stitching the two back together recovers the path the
+		// caller actually meant, while still handling a proper absolute "file:///abs/path" correctly.
+		return newFileStore(u.Host + u.Path)
+	case "s3":
+		return newS3Store(u.Host, u.Path)
+	case "gs":
+		return newGCSStore(u.Host, u.Path)
+	}
+	return nil, fmt.Errorf("unsupported cache_url scheme '%s'", u.Scheme)
+}
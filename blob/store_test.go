@@ -0,0 +1,45 @@
+package blob
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// An absolute "file://" URL must round-trip to exactly the path that was given - a regression test for a bug
+// where a relative path like "file://.dio/somedb/db" was parsed with ".dio" as the host instead of the start
+// of the path, sending the cache to "/somedb/db" rather than "./.dio/somedb/db".
+func TestNewStoreFileAbsolutePath(t *testing.T) {
+	dir, err := filepath.Abs(filepath.Join(t.TempDir(), "somedb", "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewStore("file://" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, ok := store.(*fileStore)
+	if !ok {
+		t.Fatalf("expected a *fileStore, got %T", store)
+	}
+	if fs.dir != dir {
+		t.Fatalf("expected store dir %q, got %q", dir, fs.dir)
+	}
+}
+
+// A relative "file://" URL is also accepted, and must keep the whole path (not just the portion after the
+// first "/") rather than losing its leading segment to u.Host.
+func TestNewStoreFileRelativePath(t *testing.T) {
+	store, err := NewStore("file://.dio/somedb/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, ok := store.(*fileStore)
+	if !ok {
+		t.Fatalf("expected a *fileStore, got %T", store)
+	}
+	want := filepath.Join(".dio", "somedb", "db")
+	if fs.dir != want {
+		t.Fatalf("expected store dir %q, got %q", want, fs.dir)
+	}
+}
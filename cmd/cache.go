@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/gitart/dio/blob"
+	"github.com/spf13/viper"
+)
+
+// cacheStore returns the blob.Store that holds the local (or shared) cache for db, selected via the
+// "cache_url" config option.  When unset, this defaults to the on-disk layout dio has always used, under
+// .dio/<db>/db/.
+func cacheStore(db string) (blob.Store, error) {
+	cacheURL := viper.GetString("cache_url")
+	if cacheURL == "" {
+		// url.Parse treats the first path segment after "file://" as the host, so a relative path like
+		// ".dio/<db>/db" gets split across u.Host and u.Path.  Make it absolute first, so the whole thing
+		// lands in u.Path instead.
+		dir, err := filepath.Abs(filepath.Join(".dio", db, "db"))
+		if err != nil {
+			return nil, err
+		}
+		cacheURL = fmt.Sprintf("file://%s", dir)
+	}
+	return blob.NewStore(cacheURL)
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// With no "cache_url" configured, cacheStore must resolve to the on-disk layout dio has always used
+// (.dio/<db>/db) relative to the current directory - not to some absolute path derived from mis-parsing a
+// relative "file://" URL.
+func TestCacheStoreDefaultPath(t *testing.T) {
+	viper.Set("cache_url", "")
+	defer viper.Set("cache_url", nil)
+
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	store, err := cacheStore("somedb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Put("deadbeef", strings.NewReader("content")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := filepath.Join(dir, ".dio", "somedb", "db")
+	if _, err = os.Stat(want); err != nil {
+		t.Fatalf("expected cache directory %q to have been created: %v", want, err)
+	}
+}
@@ -0,0 +1,406 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	rq "github.com/parnurzeal/gorequest"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// Default size of each chunk sent during a chunked upload.  8 MiB keeps individual POSTs quick to retry
+// without generating an excessive number of round trips for multi-GB databases.
+const defaultChunkSize int64 = 8 * 1024 * 1024
+
+// Databases larger than this are chunked automatically, even without --chunked being given explicitly.
+const chunkedAutoThreshold int64 = 100 * 1024 * 1024
+
+// chunkInfo describes a single chunk of a database file being uploaded.
+type chunkInfo struct {
+	Index    int    `json:"index"`
+	Offset   int64  `json:"offset"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+	Uploaded bool   `json:"uploaded"`
+}
+
+// uploadSession is the on-disk record of an in-progress (or resumable) chunked upload, stored at
+// .dio/<db>/upload-session.json.
+type uploadSession struct {
+	UploadID     string      `json:"upload_id"`
+	File         string      `json:"file"`
+	ChunkSize    int64       `json:"chunk_size"`
+	TotalSize    int64       `json:"total_size"`
+	ManifestHash string      `json:"manifest_hash"`
+	Chunks       []chunkInfo `json:"chunks"`
+}
+
+// pushCmdChunked forces chunked upload mode, rather than relying on the automatic size threshold.
+var pushCmdChunked bool
+
+// buildChunkManifest splits file into fixed-size chunks, computing the sha256 of each one along with a
+// rolling manifest hash covering the whole file.
+func buildChunkManifest(file string, chunkSize int64) (chunks []chunkInfo, manifestHash string, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+
+	rolling := sha256.New()
+	buf := make([]byte, chunkSize)
+	var offset int64
+	for idx := 0; offset < fi.Size(); idx++ {
+		n, rerr := io.ReadFull(f, buf)
+		if rerr != nil && rerr != io.ErrUnexpectedEOF && rerr != io.EOF {
+			return nil, "", rerr
+		}
+		sum := sha256.Sum256(buf[:n])
+		sumHex := hex.EncodeToString(sum[:])
+		chunks = append(chunks, chunkInfo{
+			Index:  idx,
+			Offset: offset,
+			Size:   int64(n),
+			SHA256: sumHex,
+		})
+		rolling.Write(sum[:])
+		offset += int64(n)
+	}
+	manifestHash = hex.EncodeToString(rolling.Sum(nil))
+	return chunks, manifestHash, nil
+}
+
+// carryOverUploadedChunks copies the "uploaded" flags from a previous session's chunks onto a freshly built
+// manifest for the same file, by index, so a resumed upload knows which chunks it can skip.  Flags are
+// copied purely by position: chunk offsets, sizes and hashes are not compared, and previous entries past the
+// end of fresh are ignored.
+func carryOverUploadedChunks(previous, fresh []chunkInfo) []chunkInfo {
+	for i := range previous {
+		if i < len(fresh) {
+			fresh[i].Uploaded = previous[i].Uploaded
+		}
+	}
+	return fresh
+}
+
+// loadUploadSession reads a previously saved upload session from disk, for resuming an interrupted chunked
+// push.  It returns a nil session (and no error) when none exists yet.
+func loadUploadSession(dbName string) (*uploadSession, error) {
+	p := filepath.Join(".dio", dbName, "upload-session.json")
+	raw, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var s uploadSession
+	if err = json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// saveUploadSession writes the given session to disk, so the upload can be resumed if interrupted.
+func saveUploadSession(dbName string, s *uploadSession) error {
+	dir := filepath.Join(".dio", dbName)
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "upload-session.json"), raw, 0644)
+}
+
+// removeUploadSession deletes the saved session for dbName, once a chunked upload has finalized successfully.
+func removeUploadSession(dbName string) error {
+	p := filepath.Join(".dio", dbName, "upload-session.json")
+	err := os.Remove(p)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// uploadedOffsets asks the server which chunks (by index, from its Uploaded-Chunks header) it already has
+// for the given upload session, by sending a HEAD request against the chunk upload endpoint.  Servers which
+// don't support this simply return no chunk indices, and every chunk is (re-)sent.
+func uploadedOffsets(dbName, uploadID string) (map[int]bool, error) {
+	chunkURL := fmt.Sprintf("%s/%s/%s/upload/chunk", cloud, certUser, dbName)
+	resp, _, errs := rq.New().TLSClientConfig(&TLSConfig).Head(chunkURL).
+		Query(fmt.Sprintf("upload_id=%s", url.QueryEscape(uploadID))).
+		End()
+	have := map[int]bool{}
+	if errs != nil || resp == nil {
+		// The server may not support resume discovery at all - that's fine, we just re-send every chunk
+		return have, nil
+	}
+	if hdr := resp.Header.Get("Uploaded-Chunks"); hdr != "" {
+		for _, s := range strings.Split(hdr, ",") {
+			if idx, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+				have[idx] = true
+			}
+		}
+	}
+	return have, nil
+}
+
+// pushChunked uploads file using the chunked, resumable upload path: the file is split into fixed size
+// chunks which are POSTed individually with Content-Range headers, keyed by an upload session id.  An
+// interrupted upload can be resumed by re-running the same push command, as the chunk manifest and
+// already-sent chunks are recorded in .dio/<db>/upload-session.json.  branch and commit are the already-
+// resolved target branch and parent commit (see resolveBranchAndCommit), matching what pushOne uses.
+func pushChunked(file, dbName, branch, commit, pushAuthor, pushEmail string) (result pushResult) {
+	result.file = file
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		result.err = err
+		return
+	}
+	result.size = fi.Size()
+
+	chunks, manifestHash, err := buildChunkManifest(file, defaultChunkSize)
+	if err != nil {
+		result.err = err
+		return
+	}
+
+	// Compute the whole file's sha256 up front (streamed, so a multi-GB database is never held in memory at
+	// once): it's needed for hook context, for the optional commit signature, and for seeding the cache once
+	// the upload finalizes.
+	dbSHA256, err := fileSHA256(file)
+	if err != nil {
+		result.err = err
+		return
+	}
+
+	if err = runPrePushHooks(prePushContext{
+		db:     dbName,
+		file:   file,
+		branch: branch,
+		sha256: dbSHA256,
+		msg:    pushCmdMsg,
+		author: pushAuthor,
+		email:  pushEmail,
+		size:   fi.Size(),
+		force:  pushCmdForce,
+	}); err != nil {
+		result.err = err
+		return
+	}
+
+	// Reuse an existing session for this file if its manifest hash still matches (i.e. the file hasn't
+	// changed since the last attempt), otherwise start a fresh one.
+	session, err := loadUploadSession(dbName)
+	if err != nil {
+		result.err = err
+		return
+	}
+	if session == nil || session.ManifestHash != manifestHash {
+		session = &uploadSession{
+			UploadID:     fmt.Sprintf("%s-%d", manifestHash[:12], time.Now().Unix()),
+			File:         file,
+			ChunkSize:    defaultChunkSize,
+			TotalSize:    fi.Size(),
+			ManifestHash: manifestHash,
+			Chunks:       chunks,
+		}
+	} else {
+		session.Chunks = carryOverUploadedChunks(session.Chunks, chunks)
+	}
+	if err = saveUploadSession(dbName, session); err != nil {
+		result.err = err
+		return
+	}
+
+	// Ask the server which chunks it already has, in case a previous attempt got further than our local
+	// session file recorded (e.g. the session file was lost, but the server-side upload wasn't)
+	have, err := uploadedOffsets(dbName, session.UploadID)
+	if err != nil {
+		result.err = err
+		return
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		result.err = err
+		return
+	}
+	defer f.Close()
+
+	chunkURL := fmt.Sprintf("%s/%s/%s/upload/chunk", cloud, certUser, dbName)
+	for i, c := range session.Chunks {
+		if c.Uploaded || have[c.Index] {
+			continue
+		}
+		buf := make([]byte, c.Size)
+		if _, err = f.ReadAt(buf, c.Offset); err != nil && err != io.EOF {
+			result.err = err
+			return
+		}
+		contentRange := fmt.Sprintf("bytes %d-%d/%d", c.Offset, c.Offset+c.Size-1, session.TotalSize)
+		resp, _, errs := rq.New().TLSClientConfig(&TLSConfig).Post(chunkURL).
+			Query(fmt.Sprintf("upload_id=%s", url.QueryEscape(session.UploadID))).
+			Query(fmt.Sprintf("index=%d", c.Index)).
+			Query(fmt.Sprintf("sha256=%s", c.SHA256)).
+			Set("Content-Range", contentRange).
+			Send(string(buf)).
+			End()
+		if errs != nil {
+			result.err = errors.New(fmt.Sprintf("Error uploading chunk %d of '%s': %v", c.Index, file, errs[0]))
+			return
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+			result.err = errors.New(fmt.Sprintf("Chunk %d of '%s' failed with HTTP status %d", c.Index, file,
+				resp.StatusCode))
+			return
+		}
+
+		// Record the chunk as sent, and persist the session so the upload can resume from here if interrupted
+		session.Chunks[i].Uploaded = true
+		if err = saveUploadSession(dbName, session); err != nil {
+			result.err = err
+			return
+		}
+	}
+
+	// If signing is enabled, canonicalize the commit metadata (including the whole-file sha256 computed
+	// above) and produce a detached signature for it, to be sent along with the finalize request.
+	var sigMeta signedCommitMeta
+	var sigArmored string
+	if signingEnabled() {
+		sigMeta = signedCommitMeta{
+			Author:    pushAuthor,
+			Email:     pushEmail,
+			Message:   pushCmdMsg,
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Parent:    commit,
+			SHA256:    dbSHA256,
+			Branch:    branch,
+			Licence:   pushCmdLicence,
+		}
+		sigArmored, err = buildSignature(sigMeta, viper.GetString("signingkey"))
+		if err != nil {
+			result.err = err
+			return
+		}
+	}
+
+	// All chunks are on the server, so finalize the commit
+	finalizeURL := fmt.Sprintf("%s/%s/%s/upload/finalize", cloud, certUser, dbName)
+	finalizeBody := map[string]interface{}{
+		"upload_id":     session.UploadID,
+		"manifest_hash": session.ManifestHash,
+		"branch":        branch,
+		"commitmsg":     pushCmdMsg,
+		"commit":        commit,
+		"public":        pushCmdPublic,
+		"force":         pushCmdForce,
+		"licence":       pushCmdLicence,
+		"author":        pushAuthor,
+		"email":         pushEmail,
+	}
+	if sigArmored != "" {
+		finalizeBody["signature"] = sigArmored
+	}
+	req := rq.New().TLSClientConfig(&TLSConfig).Post(finalizeURL).
+		Type("json").
+		Send(finalizeBody)
+	resp, body, errs := req.End()
+	if errs != nil {
+		result.err = errors.New(fmt.Sprintf("Error finalizing chunked upload of '%s': %v", file, errs[0]))
+		return
+	}
+	if resp.StatusCode != http.StatusCreated {
+		result.err = errors.New(fmt.Sprintf("Finalizing chunked upload failed with HTTP status %d - '%v'",
+			resp.StatusCode, resp.Status))
+		return
+	}
+
+	parsedResponse := map[string]string{}
+	if err = json.Unmarshal([]byte(body), &parsedResponse); err != nil {
+		result.err = errors.New(fmt.Sprintf("Error parsing server response: '%v'", err.Error()))
+		return
+	}
+
+	if _, err = updateMetadata(dbName, true); err != nil {
+		result.err = err
+		return
+	}
+	comFile := filepath.Join(".dio", dbName, "commit")
+	if err = ioutil.WriteFile(comFile, []byte(parsedResponse["commit_id"]), 0644); err != nil {
+		result.err = err
+		return
+	}
+
+	// Seed the shared cache store with the just-uploaded content, same as the single-shot push path does
+	store, err := cacheStore(dbName)
+	if err != nil {
+		result.err = err
+		return
+	}
+	dbFile, err := os.Open(file)
+	if err != nil {
+		result.err = err
+		return
+	}
+	putErr := store.Put(dbSHA256, dbFile)
+	dbFile.Close()
+	if putErr != nil {
+		result.err = putErr
+		return
+	}
+
+	// If this commit was signed, save the signature (and the metadata it covers) locally, so "dio verify"
+	// can check it later without needing to re-derive the canonical string from the server
+	if sigArmored != "" {
+		if err = saveCommitSignature(dbName, parsedResponse["commit_id"], sigMeta, sigArmored); err != nil {
+			result.err = err
+			return
+		}
+	}
+
+	// The upload completed and finalized, so the resumable session is no longer needed
+	if err = removeUploadSession(dbName); err != nil {
+		result.err = err
+		return
+	}
+
+	result.commitID = parsedResponse["commit_id"]
+	return
+}
+
+// fileSHA256 computes the sha256 of file's contents, streaming it rather than reading the whole (potentially
+// multi-GB) file into memory at once.
+func fileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sum := sha256.New()
+	if _, err = io.Copy(sum, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildChunkManifest must split a file into the expected number of chunks (including a short final chunk),
+// and its manifest hash must be a pure function of the content - the same bytes always produce the same
+// hash, and different bytes never collide in the cases we can easily check here.
+func TestBuildChunkManifest(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.db")
+	content := make([]byte, 25)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	if err := os.WriteFile(file, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, manifestHash, err := buildChunkManifest(file, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks (10, 10, 5 bytes), got %d", len(chunks))
+	}
+	if chunks[0].Size != 10 || chunks[1].Size != 10 || chunks[2].Size != 5 {
+		t.Fatalf("unexpected chunk sizes: %+v", chunks)
+	}
+	if chunks[2].Offset != 20 {
+		t.Fatalf("expected final chunk to start at offset 20, got %d", chunks[2].Offset)
+	}
+
+	chunksAgain, manifestHashAgain, err := buildChunkManifest(file, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifestHashAgain != manifestHash {
+		t.Fatalf("manifest hash must be stable across runs: %q != %q", manifestHashAgain, manifestHash)
+	}
+	for i := range chunks {
+		if chunks[i].SHA256 != chunksAgain[i].SHA256 {
+			t.Fatalf("chunk %d sha256 changed across runs", i)
+		}
+	}
+
+	if err = os.WriteFile(file, append(content, 'x'), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, changedHash, err := buildChunkManifest(file, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changedHash == manifestHash {
+		t.Fatal("expected manifest hash to change when file content changes")
+	}
+}
+
+// carryOverUploadedChunks must preserve "uploaded" flags by index so a resumed upload skips chunks it
+// already sent, without disturbing the freshly rebuilt chunk metadata (offsets, sizes, hashes) otherwise.
+func TestCarryOverUploadedChunks(t *testing.T) {
+	previous := []chunkInfo{
+		{Index: 0, SHA256: "aaa", Uploaded: true},
+		{Index: 1, SHA256: "bbb", Uploaded: false},
+		{Index: 2, SHA256: "ccc", Uploaded: true},
+	}
+	fresh := []chunkInfo{
+		{Index: 0, SHA256: "aaa"},
+		{Index: 1, SHA256: "bbb"},
+		{Index: 2, SHA256: "ccc"},
+	}
+
+	result := carryOverUploadedChunks(previous, fresh)
+	if !result[0].Uploaded || result[1].Uploaded || !result[2].Uploaded {
+		t.Fatalf("expected uploaded flags [true false true], got %+v", result)
+	}
+}
+
+// A resumed upload whose manifest now has fewer chunks than the previous session (e.g. a smaller file) must
+// not panic or carry over flags past the end of the new manifest.
+func TestCarryOverUploadedChunksShorterManifest(t *testing.T) {
+	previous := []chunkInfo{
+		{Index: 0, Uploaded: true},
+		{Index: 1, Uploaded: true},
+		{Index: 2, Uploaded: true},
+	}
+	fresh := []chunkInfo{
+		{Index: 0},
+	}
+
+	result := carryOverUploadedChunks(previous, fresh)
+	if len(result) != 1 || !result[0].Uploaded {
+		t.Fatalf("expected single carried-over chunk, got %+v", result)
+	}
+}
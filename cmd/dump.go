@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+
+	rq "github.com/parnurzeal/gorequest"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var dumpCmdOutput string
+
+// dumpManifest is the top level description of a dump produced by "dio dump", written to
+// <output>/manifest.yaml.  "dio restore" reads this back to replay the export through the normal push flow.
+type dumpManifest struct {
+	GeneratedBy string         `yaml:"generated_by"`
+	Databases   []dumpDatabase `yaml:"databases"`
+}
+
+// dumpDatabase records enough about a single database to drive a restore: its branch heads, and the ordered
+// list of commits making up its history.  The full metadata for each commit (author, message, parent, ...)
+// lives alongside it under commits/, rather than being duplicated here.
+type dumpDatabase struct {
+	Name     string            `yaml:"name"`
+	Branches map[string]string `yaml:"branches"` // branch name -> head commit id
+	Commits  []dumpCommitEntry `yaml:"commits"`  // oldest first
+}
+
+// dumpCommitEntry is the manifest's pointer from a commit id to the deduplicated blob holding its database
+// content.
+type dumpCommitEntry struct {
+	ID     string `yaml:"id"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// Walks every database the authenticated user has access to and writes a self-contained on-disk archive:
+// for each one, all branches, all commits (as metadata JSON under commits/), and each unique database blob
+// keyed by sha256 under blobs/, plus licences/ and a top-level manifest.yaml describing the whole export.
+// Pair with "dio restore" to bring an export back onto a DBHub.io server.
+var dumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Dump every accessible database to a self-contained on-disk archive",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dbs, err := listDatabases()
+		if err != nil {
+			return err
+		}
+		if len(dbs) == 0 {
+			return errors.New("No databases found for this user")
+		}
+
+		blobDir := filepath.Join(dumpCmdOutput, "blobs")
+		licenceDir := filepath.Join(dumpCmdOutput, "licences")
+		commitDir := filepath.Join(dumpCmdOutput, "commits")
+		for _, dir := range []string{dumpCmdOutput, blobDir, licenceDir, commitDir} {
+			if err = os.MkdirAll(dir, 0770); err != nil {
+				return err
+			}
+		}
+
+		seenBlobs := make(map[string]bool)
+		seenLicences := make(map[string]bool)
+		manifest := dumpManifest{GeneratedBy: "dio dump"}
+		for _, db := range dbs {
+			meta, err := updateMetadata(db, false)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Error retrieving metadata for '%s': %v", db, err))
+			}
+
+			entry := dumpDatabase{Name: db, Branches: make(map[string]string)}
+			for branch := range meta.Branches {
+				head, err := branchHeadCommit(db, branch)
+				if err != nil {
+					return err
+				}
+				entry.Branches[branch] = head
+			}
+
+			// Fetch every commit's metadata first, recording its parent, so the manifest can be written in
+			// actual parent-chain order below - map iteration order is randomized, and "dio restore" needs
+			// each commit's parent to already exist on the server before it's replayed.
+			parentOf := make(map[string]string, len(meta.Commits))
+			shaOf := make(map[string]string, len(meta.Commits))
+			for commitID := range meta.Commits {
+				sha, licence, parent, err := dumpCommitMeta(db, commitID, commitDir)
+				if err != nil {
+					return err
+				}
+				parentOf[commitID] = parent
+				shaOf[commitID] = sha
+
+				if sha != "" && !seenBlobs[sha] {
+					if err = dumpBlob(db, commitID, sha, blobDir); err != nil {
+						return err
+					}
+					seenBlobs[sha] = true
+				}
+				if licence != "" && !seenLicences[licence] {
+					if err = dumpLicence(db, licence, licenceDir); err != nil {
+						return err
+					}
+					seenLicences[licence] = true
+				}
+			}
+
+			for _, commitID := range orderCommitsByParentChain(parentOf) {
+				entry.Commits = append(entry.Commits, dumpCommitEntry{ID: commitID, SHA256: shaOf[commitID]})
+			}
+
+			manifest.Databases = append(manifest.Databases, entry)
+		}
+
+		raw, err := yaml.Marshal(&manifest)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dumpCmdOutput, "manifest.yaml"), raw, 0644)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(dumpCmd)
+	dumpCmd.Flags().StringVar(&dumpCmdOutput, "output", "dio-dump", "Directory to write the dump archive to")
+}
+
+// listDatabases asks the server for every database name the authenticated user has access to.
+func listDatabases() (dbs []string, err error) {
+	listURL := fmt.Sprintf("%s/%s", cloud, certUser)
+	resp, body, errs := rq.New().TLSClientConfig(&TLSConfig).Get(listURL).Query("list=1").End()
+	if errs != nil {
+		return nil, errors.New(fmt.Sprintf("Error listing databases: %v", errs[0]))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Listing databases failed with HTTP status %d", resp.StatusCode))
+	}
+	if err = json.Unmarshal([]byte(body), &dbs); err != nil {
+		return nil, err
+	}
+	return dbs, nil
+}
+
+// branchHeadCommit looks up the commit id currently at the tip of branch for db.
+func branchHeadCommit(db, branch string) (commitID string, err error) {
+	branchURL := fmt.Sprintf("%s/%s/%s/branch", cloud, certUser, db)
+	resp, body, errs := rq.New().TLSClientConfig(&TLSConfig).Get(branchURL).
+		Query(fmt.Sprintf("branch=%s", url.QueryEscape(branch))).End()
+	if errs != nil {
+		return "", errors.New(fmt.Sprintf("Error retrieving branch '%s' of '%s': %v", branch, db, errs[0]))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("Retrieving branch '%s' of '%s' failed with HTTP status %d", branch,
+			db, resp.StatusCode))
+	}
+	parsed := map[string]string{}
+	if err = json.Unmarshal([]byte(body), &parsed); err != nil {
+		return "", err
+	}
+	return parsed["commit_id"], nil
+}
+
+// dumpCommitMeta fetches a single commit's metadata from the server and writes it to
+// <commitDir>/<db>-<id>.json, returning its database sha256 and licence so the caller can dedupe the blob
+// and licence downloads that follow, and its parent commit id so the manifest can later be ordered into an
+// actual parent-chain sequence.
+func dumpCommitMeta(db, commitID, commitDir string) (sha256Sum, licence, parent string, err error) {
+	commitURL := fmt.Sprintf("%s/%s/%s/commit/%s", cloud, certUser, db, commitID)
+	resp, body, errs := rq.New().TLSClientConfig(&TLSConfig).Get(commitURL).End()
+	if errs != nil {
+		return "", "", "", errors.New(fmt.Sprintf("Error retrieving commit '%s' of '%s': %v", commitID, db,
+			errs[0]))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", "", errors.New(fmt.Sprintf("Retrieving commit '%s' of '%s' failed with HTTP status %d",
+			commitID, db, resp.StatusCode))
+	}
+	if err = ioutil.WriteFile(filepath.Join(commitDir, db+"-"+commitID+".json"), []byte(body), 0644); err != nil {
+		return "", "", "", err
+	}
+	parsed := map[string]string{}
+	if err = json.Unmarshal([]byte(body), &parsed); err != nil {
+		return "", "", "", err
+	}
+	return parsed["sha256"], parsed["licence"], parsed["parent"], nil
+}
+
+// orderCommitsByParentChain sorts the commit ids of parentOf (commit id -> parent id) into parent-chain
+// order: every commit appears after its parent, so replaying them in this order (as "dio restore" does)
+// never references a parent that hasn't been pushed yet.  Each commit's depth is its distance from a root
+// (a commit with no parent, or whose parent fell outside this dump); sorting by depth is enough since a
+// commit's depth is always greater than its parent's.
+func orderCommitsByParentChain(parentOf map[string]string) []string {
+	depth := make(map[string]int, len(parentOf))
+	var depthOf func(id string) int
+	depthOf = func(id string) int {
+		if d, ok := depth[id]; ok {
+			return d
+		}
+		// Mark as visited before recursing, so a corrupt/cyclic parent chain can't recurse forever.
+		depth[id] = 0
+		parent := parentOf[id]
+		if parent == "" {
+			return 0
+		}
+		if _, ok := parentOf[parent]; !ok {
+			return 0
+		}
+		d := depthOf(parent) + 1
+		depth[id] = d
+		return d
+	}
+
+	ids := make([]string, 0, len(parentOf))
+	for id := range parentOf {
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		depthOf(id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if depth[ids[i]] != depth[ids[j]] {
+			return depth[ids[i]] < depth[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// dumpBlob downloads the database content as of commitID (identified by its sha256) and writes it to
+// <blobDir>/<sha256>.
+func dumpBlob(db, commitID, sha256Sum, blobDir string) error {
+	dbURL := fmt.Sprintf("%s/%s/%s", cloud, certUser, db)
+	resp, body, errs := rq.New().TLSClientConfig(&TLSConfig).Get(dbURL).
+		Query(fmt.Sprintf("commit=%s", url.QueryEscape(commitID))).End()
+	if errs != nil {
+		return errors.New(fmt.Sprintf("Error downloading blob for commit '%s' of '%s': %v", commitID, db, errs[0]))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("Downloading blob for commit '%s' of '%s' failed with HTTP status %d",
+			commitID, db, resp.StatusCode))
+	}
+	return ioutil.WriteFile(filepath.Join(blobDir, sha256Sum), []byte(body), 0644)
+}
+
+// dumpLicence downloads the text of a licence (referenced by one or more commits in this dump) and writes it
+// to <licenceDir>/<licence>.txt.
+func dumpLicence(db, licence, licenceDir string) error {
+	licenceURL := fmt.Sprintf("%s/%s/%s/licence", cloud, certUser, db)
+	resp, body, errs := rq.New().TLSClientConfig(&TLSConfig).Get(licenceURL).
+		Query(fmt.Sprintf("licence=%s", url.QueryEscape(licence))).End()
+	if errs != nil {
+		return errors.New(fmt.Sprintf("Error downloading licence '%s': %v", licence, errs[0]))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("Downloading licence '%s' failed with HTTP status %d", licence,
+			resp.StatusCode))
+	}
+	return ioutil.WriteFile(filepath.Join(licenceDir, licence+".txt"), []byte(body), 0644)
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import "testing"
+
+// orderCommitsByParentChain must place every commit after its parent, regardless of the (randomized) map
+// iteration order it's fed - this is what "dio restore" relies on to never push a child before its parent
+// exists on the server.
+func TestOrderCommitsByParentChain(t *testing.T) {
+	parentOf := map[string]string{
+		"c4": "c3",
+		"c1": "",
+		"c3": "c2",
+		"c2": "c1",
+	}
+
+	for i := 0; i < 20; i++ {
+		ordered := orderCommitsByParentChain(parentOf)
+		if len(ordered) != len(parentOf) {
+			t.Fatalf("expected %d commits, got %d", len(parentOf), len(ordered))
+		}
+		position := make(map[string]int, len(ordered))
+		for i, id := range ordered {
+			position[id] = i
+		}
+		for id, parent := range parentOf {
+			if parent == "" {
+				continue
+			}
+			if position[parent] >= position[id] {
+				t.Fatalf("parent %q (at %d) must come before child %q (at %d); order was %v",
+					parent, position[parent], id, position[id], ordered)
+			}
+		}
+	}
+}
+
+// A commit whose recorded parent isn't part of the dump (e.g. history predating the export window) must be
+// treated as a root rather than breaking the sort.
+func TestOrderCommitsByParentChainMissingParent(t *testing.T) {
+	parentOf := map[string]string{
+		"c2": "c1",
+		"c1": "not-in-this-dump",
+	}
+	ordered := orderCommitsByParentChain(parentOf)
+	if len(ordered) != 2 || ordered[0] != "c1" || ordered[1] != "c2" {
+		t.Fatalf("expected [c1 c2], got %v", ordered)
+	}
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// prePushContext carries everything a pre-push hook (built-in or external) needs to know about the commit
+// about to be pushed.
+type prePushContext struct {
+	db     string
+	file   string
+	branch string
+	sha256 string
+	msg    string
+	author string
+	email  string
+	size   int64
+	force  bool
+}
+
+// runPrePushHooks runs the built-in checks, then any executable hooks found under .dio/hooks/pre-push and
+// .dio/<db>/hooks/pre-push, in that order.  The first failure (built-in or external) aborts the push.
+func runPrePushHooks(ctx prePushContext) error {
+	if err := hookMaxSize(ctx); err != nil {
+		return err
+	}
+	if err := hookProtectedBranch(ctx); err != nil {
+		return err
+	}
+
+	for _, p := range []string{
+		filepath.Join(".dio", "hooks", "pre-push"),
+		filepath.Join(".dio", ctx.db, "hooks", "pre-push"),
+	} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if fi.Mode()&0111 == 0 {
+			// Not executable, so skip it rather than failing the push outright
+			continue
+		}
+		if err := runHookExecutable(p, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runHookExecutable runs a single pre-push hook executable, passing commit details through the environment
+// (DIO_DB, DIO_BRANCH, DIO_SHA256, DIO_MSG, DIO_AUTHOR, DIO_EMAIL), mirroring the way Gogs/Gitea pre-receive
+// hooks are invoked.  A non-zero exit aborts the push.
+func runHookExecutable(path string, ctx prePushContext) error {
+	cmd := exec.Command(path, ctx.file)
+	cmd.Env = append(os.Environ(),
+		"DIO_DB="+ctx.db,
+		"DIO_BRANCH="+ctx.branch,
+		"DIO_SHA256="+ctx.sha256,
+		"DIO_MSG="+ctx.msg,
+		"DIO_AUTHOR="+ctx.author,
+		"DIO_EMAIL="+ctx.email,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.New(fmt.Sprintf("pre-push hook '%s' rejected the push: %s", path, string(out)))
+	}
+	return nil
+}
+
+// hookMaxSize enforces the built-in "max-size" policy: reject pushes of databases over a configured size.
+func hookMaxSize(ctx prePushContext) error {
+	maxMiB := viper.GetInt64("hooks.max-size-mib")
+	if maxMiB <= 0 {
+		return nil
+	}
+	maxBytes := maxMiB * 1024 * 1024
+	if ctx.size > maxBytes {
+		return errors.New(fmt.Sprintf("Database '%s' is %d bytes, which is over the configured max-size limit of %d MiB",
+			ctx.file, ctx.size, maxMiB))
+	}
+	return nil
+}
+
+// hookProtectedBranch enforces the built-in "protected-branch" policy: refuse direct pushes to configured
+// branch names, unless --force was given and the local config grants the bypass-protection role.
+func hookProtectedBranch(ctx prePushContext) error {
+	protected := viper.GetStringSlice("hooks.protected-branches")
+	isProtected := false
+	for _, b := range protected {
+		if b == ctx.branch {
+			isProtected = true
+			break
+		}
+	}
+	if !isProtected {
+		return nil
+	}
+	if ctx.force && viper.GetBool("bypass-protection") {
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Branch '%s' is protected.  Use --force with the bypass-protection role "+
+		"configured to push to it directly", ctx.branch))
+}
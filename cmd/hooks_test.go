@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// hookMaxSize must be a no-op when unconfigured (or configured to zero/negative, which doesn't make sense
+// as a limit), reject anything over the configured limit, and allow anything at or under it.
+func TestHookMaxSize(t *testing.T) {
+	defer viper.Set("hooks.max-size-mib", nil)
+
+	viper.Set("hooks.max-size-mib", 0)
+	if err := hookMaxSize(prePushContext{size: 1 << 30}); err != nil {
+		t.Fatalf("expected no limit enforced when max-size-mib is 0, got: %v", err)
+	}
+
+	viper.Set("hooks.max-size-mib", 1)
+	if err := hookMaxSize(prePushContext{size: 1024 * 1024}); err != nil {
+		t.Fatalf("expected a database exactly at the limit to be allowed, got: %v", err)
+	}
+	if err := hookMaxSize(prePushContext{size: 1024*1024 + 1}); err == nil {
+		t.Fatal("expected a database one byte over the limit to be rejected")
+	}
+}
+
+// hookProtectedBranch must only reject pushes to a branch named in hooks.protected-branches, and even then
+// only when --force and the bypass-protection role aren't both present.
+func TestHookProtectedBranch(t *testing.T) {
+	defer viper.Set("hooks.protected-branches", nil)
+	defer viper.Set("bypass-protection", nil)
+	viper.Set("hooks.protected-branches", []string{"master", "release"})
+
+	if err := hookProtectedBranch(prePushContext{branch: "feature/x"}); err != nil {
+		t.Fatalf("expected an unprotected branch to be allowed, got: %v", err)
+	}
+
+	viper.Set("bypass-protection", false)
+	if err := hookProtectedBranch(prePushContext{branch: "master"}); err == nil {
+		t.Fatal("expected a direct push to a protected branch to be rejected")
+	}
+	if err := hookProtectedBranch(prePushContext{branch: "master", force: true}); err == nil {
+		t.Fatal("expected --force alone (without the bypass-protection role) to still be rejected")
+	}
+
+	viper.Set("bypass-protection", true)
+	if err := hookProtectedBranch(prePushContext{branch: "master"}); err == nil {
+		t.Fatal("expected the bypass-protection role alone (without --force) to still be rejected")
+	}
+	if err := hookProtectedBranch(prePushContext{branch: "master", force: true}); err != nil {
+		t.Fatalf("expected --force with the bypass-protection role to be allowed, got: %v", err)
+	}
+}
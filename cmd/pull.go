@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
@@ -11,29 +12,39 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
 	"time"
 
 	rq "github.com/parnurzeal/gorequest"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var pullCmdBranch, pullCmdCommit string
+var pullCmdParallel int
+var pullCmdFailFast bool
 
-// Downloads a database from DBHub.io.
+// pullResult holds the outcome of downloading a single database, for collating into the end of batch summary.
+type pullResult struct {
+	db       string
+	bytes    int64
+	branch   string
+	commitID string
+	err      error
+}
+
+// Downloads one or more databases from DBHub.io.
 var pullCmd = &cobra.Command{
-	Use:   "pull [database name]",
-	Short: "Download a database from DBHub.io",
+	Use:   "pull [database name(s)]",
+	Short: "Download one or more databases from DBHub.io",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Ensure a database file was given
+		// Ensure at least one database file was given
 		if len(args) == 0 {
 			return errors.New("No database file specified")
 		}
-		// TODO: Allow giving multiple database files on the command line.  Hopefully just needs turning this
-		// TODO  into a for loop
-		if len(args) > 1 {
-			return errors.New("Only one database can be downloaded at a time (for now)")
-		}
 
 		// TODO: Add a --licence option, for automatically grabbing the licence as well
 		//       * Probably save it as <database name>-<license short name>.txt/html
@@ -43,143 +54,307 @@ var pullCmd = &cobra.Command{
 			return errors.New("Either a branch name or commit ID can be given.  Not both at the same time!")
 		}
 
-		// Retrieve metadata for the database
-		var meta metaData
-		var err error
-		db := args[0]
-		meta, err = updateMetadata(db, false) // Don't store the metadata to disk yet, in case the download fails
+		// Expand any globs given on the command line, so "dio pull *.db" behaves as people expect
+		dbs, err := expandDBArgs(args)
 		if err != nil {
 			return err
 		}
 
-		// If given, make sure the requested branch or commit exist
-		if pullCmdBranch != "" {
-			if _, ok := meta.Branches[pullCmdBranch]; ok == false {
-				return errors.New("The requested branch doesn't exist")
+		// Run the downloads through a bounded worker pool, so large batches don't hammer the server (or the local
+		// disk) all at once
+		if pullCmdParallel < 1 {
+			pullCmdParallel = 1
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		sem := make(chan struct{}, pullCmdParallel)
+		resultCh := make(chan pullResult, len(dbs))
+		var wg sync.WaitGroup
+		var numDone int32
+		for _, db := range dbs {
+			wg.Add(1)
+			go func(db string) {
+				defer wg.Done()
+				select {
+				case <-ctx.Done():
+					resultCh <- pullResult{db: db, err: errors.New("skipped, an earlier database failed")}
+					return
+				case sem <- struct{}{}:
+				}
+				defer func() { <-sem }()
+				r := pullOne(db)
+				if r.err != nil && pullCmdFailFast {
+					cancel()
+				}
+
+				// Report progress as each database finishes, rather than only printing a summary once the
+				// whole batch is done
+				done := atomic.AddInt32(&numDone, 1)
+				if r.err != nil {
+					log.Printf("[%d/%d] Failed to pull '%s': %v", done, len(dbs), db, r.err)
+				} else {
+					log.Printf("[%d/%d] Pulled '%s' (%d bytes)", done, len(dbs), db, r.bytes)
+				}
+
+				resultCh <- r
+			}(db)
+		}
+		wg.Wait()
+		close(resultCh)
+
+		// Collect the results, preserving the order the databases were requested in
+		results := make(map[string]pullResult, len(dbs))
+		for r := range resultCh {
+			results[r.db] = r
+		}
+
+		// Print a per-database summary table
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "DATABASE\tSIZE\tBRANCH/COMMIT\tRESULT")
+		var numFailed int
+		for _, db := range dbs {
+			r := results[db]
+			if r.err != nil {
+				numFailed++
+				fmt.Fprintf(w, "%s\t-\t-\tFAILED: %s\n", db, r.err.Error())
+				continue
+			}
+			ref := r.branch
+			if ref == "" {
+				ref = r.commitID
 			}
+			fmt.Fprintf(w, "%s\t%d bytes\t%s\tOK\n", db, r.bytes, ref)
+		}
+		w.Flush()
+
+		if numFailed > 0 {
+			return errors.New(fmt.Sprintf("%d of %d database(s) failed to download", numFailed, len(dbs)))
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(pullCmd)
+	pullCmd.Flags().StringVar(&pullCmdBranch, "branch", "",
+		"Remote branch the database will be downloaded from")
+	pullCmd.Flags().StringVar(&pullCmdCommit, "commit", "", "Commit ID of the database to download")
+	pullCmd.Flags().IntVar(&pullCmdParallel, "parallel", 4, "Number of databases to download concurrently")
+	pullCmd.Flags().BoolVar(&pullCmdFailFast, "fail-fast", false,
+		"Abort all remaining downloads as soon as one database fails")
+}
+
+// expandDBArgs turns the database names/globs given on the command line into a deduplicated, ordered list of
+// database names to operate on.
+func expandDBArgs(args []string) (dbs []string, err error) {
+	seen := make(map[string]bool)
+	for _, a := range args {
+		matches, err := filepath.Glob(a)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			// Not a glob (or no matches), so treat it as a literal database name
+			matches = []string{a}
 		}
-		if pullCmdCommit != "" {
-			if _, ok := meta.Commits[pullCmdCommit]; ok == false {
-				return errors.New("The requested commit doesn't exist")
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				dbs = append(dbs, m)
 			}
 		}
+	}
+	return dbs, nil
+}
+
+// pullOne downloads a single database from DBHub.io, returning a pullResult describing what happened.  It's
+// safe to call concurrently for different database names.
+func pullOne(db string) (result pullResult) {
+	result.db = db
+
+	// Retrieve metadata for the database
+	var meta metaData
+	meta, err := updateMetadata(db, false) // Don't store the metadata to disk yet, in case the download fails
+	if err != nil {
+		result.err = err
+		return
+	}
+
+	// If given, make sure the requested branch or commit exist
+	if pullCmdBranch != "" {
+		if _, ok := meta.Branches[pullCmdBranch]; ok == false {
+			result.err = errors.New("The requested branch doesn't exist")
+			return
+		}
+	}
+	if pullCmdCommit != "" {
+		if _, ok := meta.Commits[pullCmdCommit]; ok == false {
+			result.err = errors.New("The requested commit doesn't exist")
+			return
+		}
+	}
+
+	// Get hold of the configured cache store (defaults to the local .dio/<db>/db/ directory), so we can check
+	// whether the requested content is already available locally (or on shared storage) before hitting the
+	// network at all
+	store, err := cacheStore(db)
+	if err != nil {
+		result.err = err
+		return
+	}
 
-		// Download the database file
-		dbURL := fmt.Sprintf("%s/%s/%s", cloud, certUser, db)
-		req := rq.New().TLSClientConfig(&TLSConfig).Get(dbURL)
+	dbURL := fmt.Sprintf("%s/%s/%s", cloud, certUser, db)
+	buildQuery := func(req *rq.SuperAgent) *rq.SuperAgent {
 		if pullCmdBranch != "" {
-			req.Query(fmt.Sprintf("branch=%s", url.QueryEscape(pullCmdBranch)))
-		} else {
-			req.Query(fmt.Sprintf("commit=%s", url.QueryEscape(pullCmdCommit)))
+			return req.Query(fmt.Sprintf("branch=%s", url.QueryEscape(pullCmdBranch)))
 		}
-		resp, body, errs := req.End()
+		return req.Query(fmt.Sprintf("commit=%s", url.QueryEscape(pullCmdCommit)))
+	}
+
+	// Probe the server for the sha256 it would serve, without transferring the (potentially multi-GB) body,
+	// so an already-cached database can be served from the store instead of being re-downloaded
+	var body string
+	var resp *http.Response
+	headResp, _, headErrs := buildQuery(rq.New().TLSClientConfig(&TLSConfig).Head(dbURL)).End()
+	if headErrs == nil && headResp.StatusCode == http.StatusOK {
+		if sha := headResp.Header.Get("Sha256"); sha != "" {
+			if have, _ := store.Stat(sha); have {
+				rc, gerr := store.Get(sha)
+				if gerr == nil {
+					raw, rerr := ioutil.ReadAll(rc)
+					rc.Close()
+					if rerr == nil {
+						body = string(raw)
+						resp = headResp
+					}
+				}
+			}
+		}
+	}
+
+	// Not already cached (or the HEAD probe wasn't supported/successful), so download it for real
+	if body == "" {
+		var errs []error
+		resp, body, errs = buildQuery(rq.New().TLSClientConfig(&TLSConfig).Get(dbURL)).End()
 		if errs != nil {
-			log.Print("Errors when downloading database:")
+			log.Printf("Errors when downloading database '%s':", db)
 			for _, err := range errs {
 				log.Print(err.Error())
 			}
-			return errors.New("Error when downloading database")
+			result.err = errors.New("Error when downloading database")
+			return
 		}
 		if resp.StatusCode != http.StatusOK {
 			if resp.StatusCode == http.StatusNotFound {
 				if pullCmdBranch != "" {
-					return errors.New("That database & branch aren't known on DBHub.io")
+					result.err = errors.New("That database & branch aren't known on DBHub.io")
+					return
 				}
 				if pullCmdCommit != "" {
-					return errors.New(fmt.Sprintf("Requested database not found with commit %s.",
-						pullCmdCommit))
+					result.err = errors.New(fmt.Sprintf("Requested database not found with commit %s.", pullCmdCommit))
+					return
 				}
-				return errors.New("Requested database not found")
+				result.err = errors.New("Requested database not found")
+				return
 			}
-			return errors.New(fmt.Sprintf("Download failed with an error: HTTP status %d - '%v'\n",
+			result.err = errors.New(fmt.Sprintf("Download failed with an error: HTTP status %d - '%v'\n",
 				resp.StatusCode, resp.Status))
+			return
 		}
+	}
 
-		// Create the local database cache directory, if it doesn't yet exist
-		if _, err = os.Stat(filepath.Join(".dio", db, "db")); os.IsNotExist(err) {
-			err = os.MkdirAll(filepath.Join(".dio", db, "db"), 0770)
-			if err != nil {
-				return err
-			}
+	// If the server signed this commit, verify it before saving the download.  A signature that doesn't verify
+	// only stops the pull when verify.required is set; otherwise the download is saved anyway.
+	// The signature covers
+	// the downloaded bytes themselves (full commit metadata isn't available to the client at this point), so
+	// this only tells us the content hasn't been tampered with in transit or at rest on the server - it's not
+	// a substitute for "dio verify", which checks the full commit chain.
+	sig := resp.Header.Get("Signature")
+	verifyRequired := viper.GetBool("verify.required")
+	if sig != "" {
+		valid, verr := gpgVerifyDetached([]byte(body), []byte(sig), viper.GetString("verify.keyring"))
+		if verr != nil {
+			result.err = verr
+			return
+		}
+		if !valid && verifyRequired {
+			result.err = errors.New("Signature verification failed, refusing to save database (verify.required is set)")
+			return
 		}
+	} else if verifyRequired {
+		result.err = errors.New("verify.required is set, but the server didn't provide a signature for this database")
+		return
+	}
 
-		// Calculate the sha256 of the database file
-		s := sha256.Sum256([]byte(body))
-		shaSum := hex.EncodeToString(s[:])
+	// Calculate the sha256 of the database file
+	s := sha256.Sum256([]byte(body))
+	shaSum := hex.EncodeToString(s[:])
 
-		// Write the database file to disk in the cache directory
-		err = ioutil.WriteFile(filepath.Join(".dio", db, "db", shaSum), []byte(body), 0644)
-		if err != nil {
-			return err
-		}
+	// Commit the verified body to the cache store, keyed by its sha256.  The store implementation (local
+	// filesystem, S3, GCS, ...) is responsible for making this write atomic from the point of view of other
+	// readers.
+	if err = store.Put(shaSum, strings.NewReader(body)); err != nil {
+		result.err = err
+		return
+	}
 
-		// Write the database file to disk again, this time in the working directory
-		err = ioutil.WriteFile(db, []byte(body), 0644)
-		if err != nil {
-			return err
-		}
+	// Write the database file to disk again, this time in the working directory
+	err = ioutil.WriteFile(db, []byte(body), 0644)
+	if err != nil {
+		result.err = err
+		return
+	}
 
-		// If the headers included the modification-date parameter for the database, set the last accessed and last
-		// modified times on the new database file
-		if disp := resp.Header.Get("Content-Disposition"); disp != "" {
-			s := strings.Split(disp, ";")
-			if len(s) == 4 {
-				a := strings.TrimLeft(s[2], " ")
-				if strings.HasPrefix(a, "modification-date=") {
-					b := strings.Split(a, "=")
-					c := strings.Trim(b[1], "\"")
-					lastMod, err := time.Parse(time.RFC3339, c)
-					if err != nil {
-						return err
-					}
-					err = os.Chtimes(db, time.Now(), lastMod)
-					if err != nil {
-						return err
-					}
+	// If the headers included the modification-date parameter for the database, set the last accessed and last
+	// modified times on the new database file
+	if disp := resp.Header.Get("Content-Disposition"); disp != "" {
+		s := strings.Split(disp, ";")
+		if len(s) == 4 {
+			a := strings.TrimLeft(s[2], " ")
+			if strings.HasPrefix(a, "modification-date=") {
+				b := strings.Split(a, "=")
+				c := strings.Trim(b[1], "\"")
+				lastMod, err := time.Parse(time.RFC3339, c)
+				if err != nil {
+					result.err = err
+					return
+				}
+				if err = os.Chtimes(db, time.Now(), lastMod); err != nil {
+					result.err = err
+					return
 				}
 			}
 		}
+	}
 
-		// If the server provided a branch name, add it to the local metadata cache
-		if branch := resp.Header.Get("Branch"); branch != "" {
-			meta.ActiveBranch = branch
-		}
+	// If the server provided a branch name, add it to the local metadata cache
+	if branch := resp.Header.Get("Branch"); branch != "" {
+		meta.ActiveBranch = branch
+	}
 
-		// The download succeeded, so save the updated metadata to disk
-		err = saveMetadata(db, meta)
-		if err != nil {
-			return err
-		}
+	// The download succeeded, so save the updated metadata to disk
+	if err = saveMetadata(db, meta); err != nil {
+		result.err = err
+		return
+	}
 
-		if pullCmdBranch != "" {
-			_, err = numFormat.Printf("Database '%s' downloaded from %s.  Size: %d bytes\nBranch: '%s'\n", db,
-				cloud, len(body), pullCmdBranch)
-			if err != nil {
-				return err
+	result.bytes = int64(len(body))
+	result.branch = pullCmdBranch
+	if comID := resp.Header.Get("Commit-Id"); comID != "" {
+		result.commitID = comID
+
+		// Stash the server's signature for this commit locally, so "dio verify" can check it later
+		if sig != "" {
+			dir := filepath.Join(".dio", db, "commits")
+			if err = os.MkdirAll(dir, 0770); err != nil {
+				result.err = err
+				return
 			}
-			return nil
-		}
-		if comID := resp.Header.Get("Commit-Id"); comID != "" {
-			_, err = numFormat.Printf("Database '%s' downloaded from %s.  Size: %d bytes\nCommit: %s\n", db,
-				cloud, len(body), comID)
-			if err != nil {
-				return err
+			if err = ioutil.WriteFile(filepath.Join(dir, comID+".sig"), []byte(sig), 0644); err != nil {
+				result.err = err
+				return
 			}
-			return nil
-		}
-
-		// Generic success message, when branch and commit id aren't known
-		_, err = numFormat.Printf("Database '%s' downloaded.  Size: %d bytes\n", db, len(body))
-		if err != nil {
-			return err
 		}
-		return nil
-	},
-}
-
-func init() {
-	RootCmd.AddCommand(pullCmd)
-	pullCmd.Flags().StringVar(&pullCmdBranch, "branch", "",
-		"Remote branch the database will be downloaded from")
-	pullCmd.Flags().StringVar(&pullCmdCommit, "commit", "", "Commit ID of the database to download")
+	}
+	return
 }
@@ -1,6 +1,10 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,6 +13,9 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
 	"time"
 
 	rq "github.com/parnurzeal/gorequest"
@@ -22,29 +29,28 @@ var (
 	pushCmdEmail, pushCmdLicence, pushCmdMsg string
 	pushCmdName                              string
 	pushCmdForce, pushCmdPublic              bool
+	pushCmdParallel                          int
+	pushCmdFailFast                          bool
+	pushCmdSign                              bool
 )
 
-// Uploads a database to DBHub.io.
+// pushResult holds the outcome of uploading a single database, for collating into the end of batch summary.
+type pushResult struct {
+	file     string
+	commitID string
+	size     int64
+	err      error
+}
+
+// Uploads one or more databases to DBHub.io.
 var pushCmd = &cobra.Command{
-	Use:   "push [database file]",
-	Short: "Upload a database",
+	Use:   "push [database file(s)]",
+	Short: "Upload one or more databases",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Ensure a database file was given
+		// Ensure at least one database file was given
 		if len(args) == 0 {
 			return errors.New("No database file specified")
 		}
-		// TODO: Allow giving multiple database files on the command line.  Hopefully just needs turning this
-		// TODO  into a for loop
-		if len(args) > 1 {
-			return errors.New("Only one database can be uploaded at a time (for now)")
-		}
-
-		// Ensure the database file exists
-		file := args[0]
-		fi, err := os.Stat(file)
-		if err != nil {
-			return err
-		}
 
 		// Grab author name & email from the dio config file, but allow command line flags to override them
 		var pushAuthor, pushEmail string
@@ -73,91 +79,82 @@ var pushCmd = &cobra.Command{
 			return errors.New("Commit message is required!")
 		}
 
-		// Determine name to store database as
-		if pushCmdDB == "" {
-			pushCmdDB = filepath.Base(file)
-		}
-
-		// Load the branch name and commit id from saved metadata, if they exist
-		// TODO: These may be better as part of the local metadata.json cache
-		if _, err = os.Stat(filepath.Join(".dio", file, "branch")); err == nil {
-			b, err := ioutil.ReadFile(filepath.Join(".dio", file, "branch"))
-			if err != nil {
-				return err
-			}
-			pushCmdBranch = string(b)
+		// Expand any globs given on the command line, so "dio push *.db" behaves as people expect
+		files, err := expandDBArgs(args)
+		if err != nil {
+			return err
 		}
-		if _, err = os.Stat(filepath.Join(".dio", file, "commit")); err == nil {
-			c, err := ioutil.ReadFile(filepath.Join(".dio", file, "commit"))
-			if err != nil {
-				return err
-			}
-			pushCmdCommit = string(c)
-		}
-
-		// Send the file
-		dbURL := fmt.Sprintf("%s/%s/%s", cloud, certUser, file)
-		req := rq.New().TLSClientConfig(&TLSConfig).Post(dbURL).
-			Type("multipart").
-			Query(fmt.Sprintf("branch=%s", url.QueryEscape(pushCmdBranch))).
-			Query(fmt.Sprintf("commitmsg=%s", url.QueryEscape(pushCmdMsg))).
-			Query(fmt.Sprintf("lastmodified=%s", url.QueryEscape(fi.ModTime().Format(time.RFC3339)))).
-			Query(fmt.Sprintf("commit=%s", pushCmdCommit)).
-
-			//TBD Query(fmt.Sprintf("sourceurl=%s", pushCmdSrcURL)).
-
-			Query(fmt.Sprintf("public=%v", pushCmdPublic)).
-			Query(fmt.Sprintf("force=%v", pushCmdForce)).
-			//Set("database", pushCmdDB).
-			//Set("author", pushAuthor).
-			//Set("email", pushEmail).
-			SendFile(file, "", "file1")
-		if pushCmdLicence != "" {
-			req.Query(fmt.Sprintf("licence=%s", url.QueryEscape(pushCmdLicence)))
-			//req.Set("licence", pushCmdLicence)
-		}
-		resp, body, errs := req.End()
-		if errs != nil {
-			log.Print("Errors when uploading database to the cloud:")
-			for _, err := range errs {
-				log.Print(err.Error())
-			}
-			return errors.New("Error when uploading database to the cloud")
+		if len(files) > 1 && pushCmdDB != "" {
+			return errors.New("--dbname can only be used when pushing a single database")
 		}
-		if resp != nil && resp.StatusCode != http.StatusCreated {
-			return errors.New(fmt.Sprintf("Upload failed with an error: HTTP status %d - '%v'\n",
-				resp.StatusCode, resp.Status))
+
+		// Run the uploads through a bounded worker pool, so large batches don't saturate the network (or the
+		// local disk) all at once
+		if pushCmdParallel < 1 {
+			pushCmdParallel = 1
 		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		sem := make(chan struct{}, pushCmdParallel)
+		resultCh := make(chan pushResult, len(files))
+		var wg sync.WaitGroup
+		var numDone int32
+		for _, file := range files {
+			wg.Add(1)
+			go func(file string) {
+				defer wg.Done()
+				select {
+				case <-ctx.Done():
+					resultCh <- pushResult{file: file, err: errors.New("skipped, an earlier database failed")}
+					return
+				case sem <- struct{}{}:
+				}
+				defer func() { <-sem }()
+				r := pushDispatch(file, pushAuthor, pushEmail)
+				if r.err != nil && pushCmdFailFast {
+					cancel()
+				}
 
-		// Process the JSON format response data
-		parsedResponse := map[string]string{}
-		err = json.Unmarshal([]byte(body), &parsedResponse)
-		if err != nil {
-			fmt.Printf("Error parsing server response: '%v'\n", err.Error())
-			return err
+				// Report progress as each database finishes, rather than only printing a summary once the
+				// whole batch is done
+				done := atomic.AddInt32(&numDone, 1)
+				if r.err != nil {
+					log.Printf("[%d/%d] Failed to push '%s': %v", done, len(files), file, r.err)
+				} else {
+					log.Printf("[%d/%d] Pushed '%s' (%d bytes, commit %s)", done, len(files), file, r.size,
+						r.commitID)
+				}
+
+				resultCh <- r
+			}(file)
 		}
+		wg.Wait()
+		close(resultCh)
 
-		// Retrieve and store metadata for the database
-		_, err = updateMetadata(file, true)
-		if err != nil {
-			return err
+		// Collect the results, preserving the order the databases were requested in
+		results := make(map[string]pushResult, len(files))
+		for r := range resultCh {
+			results[r.file] = r
 		}
 
-		// Save the commit id in the metadata directory
-		comFile := filepath.Join(".dio", file, "commit")
-		err = ioutil.WriteFile(comFile, []byte(parsedResponse["commit_id"]), 0644)
-		if err != nil {
-			return err
+		// Print a per-database summary table
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "DATABASE\tSIZE\tCOMMIT\tRESULT")
+		var numFailed int
+		for _, file := range files {
+			r := results[file]
+			if r.err != nil {
+				numFailed++
+				fmt.Fprintf(w, "%s\t-\t-\tFAILED: %s\n", file, r.err.Error())
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%d bytes\t%s\tOK\n", file, r.size, r.commitID)
 		}
+		w.Flush()
 
-		fmt.Printf("Database uploaded to %s\n\n", cloud)
-		fmt.Printf("  * Name: %s\n", pushCmdDB)
-		fmt.Printf("    Branch: %s\n", pushCmdBranch)
-		if pushCmdLicence != "" {
-			fmt.Printf("    Licence: %s\n", pushCmdLicence)
+		if numFailed > 0 {
+			return errors.New(fmt.Sprintf("%d of %d database(s) failed to upload", numFailed, len(files)))
 		}
-		fmt.Printf("    Size: %d bytes\n", fi.Size())
-		fmt.Printf("    Commit message: %s\n\n", pushCmdMsg)
 		return nil
 	},
 }
@@ -169,7 +166,7 @@ func init() {
 		"Remote branch the database will be uploaded to")
 	pushCmd.Flags().StringVar(&pushCmdCommit, "commit", "",
 		"ID of the previous commit, for appending this new database to")
-	//pushCmd.Flags().StringVar(&pushCmdDB, "dbname", "", "Override for the database name")
+	pushCmd.Flags().StringVar(&pushCmdDB, "dbname", "", "Override for the database name")
 	//pushCmd.Flags().StringVar(&pushCmdEmail, "email", "", "Email address of the author")
 	pushCmd.Flags().BoolVar(&pushCmdForce, "force", false, "Overwrite existing commit history?")
 	pushCmd.Flags().StringVar(&pushCmdLicence, "licence", "",
@@ -177,4 +174,214 @@ func init() {
 	pushCmd.Flags().StringVar(&pushCmdMsg, "message", "",
 		"(Required) Commit message for this upload")
 	pushCmd.Flags().BoolVar(&pushCmdPublic, "public", false, "Should the database be public?")
+	pushCmd.Flags().IntVar(&pushCmdParallel, "parallel", 4, "Number of databases to upload concurrently")
+	pushCmd.Flags().BoolVar(&pushCmdFailFast, "fail-fast", false,
+		"Abort all remaining uploads as soon as one database fails")
+	pushCmd.Flags().BoolVar(&pushCmdChunked, "chunked", false,
+		"Upload using resumable chunks, instead of sending the whole file in one request")
+	pushCmd.Flags().BoolVar(&pushCmdSign, "sign", false,
+		"GPG sign the commit metadata, using the key configured via 'signingkey'")
+}
+
+// signingEnabled reports whether this push should be GPG signed, via either --sign or the "sign" config
+// option.
+func signingEnabled() bool {
+	return pushCmdSign || viper.GetBool("sign")
+}
+
+// pushDispatch picks between the single-shot and chunked upload paths for file, automatically switching to
+// chunked mode above chunkedAutoThreshold even if --chunked wasn't given explicitly.
+func pushDispatch(file, pushAuthor, pushEmail string) pushResult {
+	dbName := pushCmdDB
+	if dbName == "" {
+		dbName = filepath.Base(file)
+	}
+
+	branch, commit, err := resolveBranchAndCommit(dbName)
+	if err != nil {
+		return pushResult{file: file, err: err}
+	}
+
+	chunked := pushCmdChunked
+	if !chunked {
+		if fi, err := os.Stat(file); err == nil && fi.Size() > chunkedAutoThreshold {
+			chunked = true
+		}
+	}
+	if chunked {
+		return pushChunked(file, dbName, branch, commit, pushAuthor, pushEmail)
+	}
+	return pushOne(file, dbName, branch, commit, pushAuthor, pushEmail)
+}
+
+// resolveBranchAndCommit returns the branch/parent-commit a push to dbName should target: the saved
+// ".dio/<dbName>/branch" and ".dio/<dbName>/commit" state if present, otherwise the --branch/--commit flags.
+// Both pushOne and pushChunked must agree on this, so it's resolved once here rather than in either of them.
+func resolveBranchAndCommit(dbName string) (branch, commit string, err error) {
+	branch = pushCmdBranch
+	commit = pushCmdCommit
+	if _, err = os.Stat(filepath.Join(".dio", dbName, "branch")); err == nil {
+		b, rerr := ioutil.ReadFile(filepath.Join(".dio", dbName, "branch"))
+		if rerr != nil {
+			return "", "", rerr
+		}
+		branch = string(b)
+	}
+	if _, err = os.Stat(filepath.Join(".dio", dbName, "commit")); err == nil {
+		c, rerr := ioutil.ReadFile(filepath.Join(".dio", dbName, "commit"))
+		if rerr != nil {
+			return "", "", rerr
+		}
+		commit = string(c)
+	}
+	return branch, commit, nil
+}
+
+// pushOne uploads a single database to DBHub.io, returning a pushResult describing what happened.  It's safe
+// to call concurrently for different database files.  dbName is the name the database is (or will be) known
+// as on the server, and is used for the upload URL and all local ".dio/<dbName>/..." bookkeeping; file is
+// only where the content is read from, and may differ from dbName (e.g. --dbname, or a restored blob).  branch
+// and commit are the already-resolved target branch and parent commit (see resolveBranchAndCommit).
+func pushOne(file, dbName, branch, commit, pushAuthor, pushEmail string) (result pushResult) {
+	result.file = file
+
+	// Ensure the database file exists
+	fi, err := os.Stat(file)
+	if err != nil {
+		result.err = err
+		return
+	}
+	result.size = fi.Size()
+
+	// Compute the database's sha256 once up front: it's needed for the optional signature below, and for
+	// seeding the shared cache store once the upload succeeds
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		result.err = err
+		return
+	}
+	rawSum := sha256.Sum256(raw)
+	dbSHA256 := hex.EncodeToString(rawSum[:])
+
+	// Run pre-push hooks (built-in and any found under .dio/hooks/pre-push) before doing anything else - a
+	// rejection here should be as cheap as possible, i.e. before the database is signed or sent anywhere
+	if err = runPrePushHooks(prePushContext{
+		db:     dbName,
+		file:   file,
+		branch: branch,
+		sha256: dbSHA256,
+		msg:    pushCmdMsg,
+		author: pushAuthor,
+		email:  pushEmail,
+		size:   fi.Size(),
+		force:  pushCmdForce,
+	}); err != nil {
+		result.err = err
+		return
+	}
+
+	// If signing is enabled, canonicalize the commit metadata and produce a detached signature for it before
+	// anything is sent to the server
+	var sigMeta signedCommitMeta
+	var sigArmored string
+	if signingEnabled() {
+		sigMeta = signedCommitMeta{
+			Author:    pushAuthor,
+			Email:     pushEmail,
+			Message:   pushCmdMsg,
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Parent:    commit,
+			SHA256:    dbSHA256,
+			Branch:    branch,
+			Licence:   pushCmdLicence,
+		}
+		sigArmored, err = buildSignature(sigMeta, viper.GetString("signingkey"))
+		if err != nil {
+			result.err = err
+			return
+		}
+	}
+
+	// Send the file
+	dbURL := fmt.Sprintf("%s/%s/%s", cloud, certUser, dbName)
+	req := rq.New().TLSClientConfig(&TLSConfig).Post(dbURL).
+		Type("multipart").
+		Query(fmt.Sprintf("branch=%s", url.QueryEscape(branch))).
+		Query(fmt.Sprintf("commitmsg=%s", url.QueryEscape(pushCmdMsg))).
+		Query(fmt.Sprintf("lastmodified=%s", url.QueryEscape(fi.ModTime().Format(time.RFC3339)))).
+		Query(fmt.Sprintf("commit=%s", commit)).
+
+		//TBD Query(fmt.Sprintf("sourceurl=%s", pushCmdSrcURL)).
+
+		Query(fmt.Sprintf("public=%v", pushCmdPublic)).
+		Query(fmt.Sprintf("force=%v", pushCmdForce)).
+		//Set("database", dbName).
+		//Set("author", pushAuthor).
+		//Set("email", pushEmail).
+		SendFile(file, "", "file1")
+	if pushCmdLicence != "" {
+		req.Query(fmt.Sprintf("licence=%s", url.QueryEscape(pushCmdLicence)))
+		//req.Set("licence", pushCmdLicence)
+	}
+	if sigArmored != "" {
+		req.Query(fmt.Sprintf("signature=%s", url.QueryEscape(sigArmored)))
+	}
+	resp, body, errs := req.End()
+	if errs != nil {
+		log.Printf("Errors when uploading database '%s' to the cloud:", dbName)
+		for _, err := range errs {
+			log.Print(err.Error())
+		}
+		result.err = errors.New("Error when uploading database to the cloud")
+		return
+	}
+	if resp != nil && resp.StatusCode != http.StatusCreated {
+		result.err = errors.New(fmt.Sprintf("Upload failed with an error: HTTP status %d - '%v'\n",
+			resp.StatusCode, resp.Status))
+		return
+	}
+
+	// Process the JSON format response data
+	parsedResponse := map[string]string{}
+	if err = json.Unmarshal([]byte(body), &parsedResponse); err != nil {
+		result.err = errors.New(fmt.Sprintf("Error parsing server response: '%v'", err.Error()))
+		return
+	}
+
+	// Retrieve and store metadata for the database
+	if _, err = updateMetadata(dbName, true); err != nil {
+		result.err = err
+		return
+	}
+
+	// Save the commit id in the metadata directory
+	comFile := filepath.Join(".dio", dbName, "commit")
+	if err = ioutil.WriteFile(comFile, []byte(parsedResponse["commit_id"]), 0644); err != nil {
+		result.err = err
+		return
+	}
+
+	// Seed the shared cache store with the just-uploaded content, so a clone on another machine (or CI
+	// runner) can be served from there instead of re-downloading it from DBHub.io
+	store, err := cacheStore(dbName)
+	if err != nil {
+		result.err = err
+		return
+	}
+	if err = store.Put(dbSHA256, bytes.NewReader(raw)); err != nil {
+		result.err = err
+		return
+	}
+
+	// If this commit was signed, save the signature (and the metadata it covers) locally, so "dio verify"
+	// can check it later without needing to re-derive the canonical string from the server
+	if sigArmored != "" {
+		if err = saveCommitSignature(dbName, parsedResponse["commit_id"], sigMeta, sigArmored); err != nil {
+			result.err = err
+			return
+		}
+	}
+
+	result.commitID = parsedResponse["commit_id"]
+	return
 }
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var restoreCmdForce bool
+
+// Re-pushes a dump produced by "dio dump" back onto a DBHub.io server: walks its manifest and replays each
+// database's commit history, oldest first, through the normal push flow.
+var restoreCmd = &cobra.Command{
+	Use:   "restore [dump directory]",
+	Short: "Restore a dump produced by 'dio dump' onto a DBHub.io server",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("Exactly one dump directory must be given")
+		}
+		dir := args[0]
+
+		raw, err := ioutil.ReadFile(filepath.Join(dir, "manifest.yaml"))
+		if err != nil {
+			return err
+		}
+		var manifest dumpManifest
+		if err = yaml.Unmarshal(raw, &manifest); err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "DATABASE\tCOMMITS\tRESULT")
+		var numFailed int
+		for _, db := range manifest.Databases {
+			if err = restoreDatabase(dir, db); err != nil {
+				numFailed++
+				fmt.Fprintf(w, "%s\t%d\tFAILED: %s\n", db.Name, len(db.Commits), err.Error())
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%d\tOK\n", db.Name, len(db.Commits))
+		}
+		w.Flush()
+
+		if numFailed > 0 {
+			return errors.New(fmt.Sprintf("%d of %d database(s) failed to restore", numFailed, len(manifest.Databases)))
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(restoreCmd)
+	restoreCmd.Flags().BoolVar(&restoreCmdForce, "force", false,
+		"Preserve the original commit ids, overwriting any existing history on the server")
+}
+
+// restoreDatabase replays every commit recorded for db, oldest first, by pushing its dumped blob back through
+// pushDispatch with the original commit metadata - this gives restore the same automatic chunked-upload
+// behavior as a normal push, which matters for the multi-GB databases dump/restore are meant for.  --force is
+// required to preserve the original commit ids rather than having the server mint new ones.
+func restoreDatabase(dir string, db dumpDatabase) error {
+	for _, c := range db.Commits {
+		commitMeta, err := readDumpedCommitMeta(dir, db.Name, c.ID)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error reading dumped metadata for commit '%s': %v", c.ID, err))
+		}
+
+		blobPath := filepath.Join(dir, "blobs", c.SHA256)
+		if _, err = os.Stat(blobPath); err != nil {
+			return errors.New(fmt.Sprintf("Missing blob '%s' for commit '%s'", c.SHA256, c.ID))
+		}
+
+		pushCmdDB = db.Name
+		pushCmdBranch = commitMeta["branch"]
+		pushCmdCommit = commitMeta["parent"]
+		pushCmdMsg = commitMeta["message"]
+		pushCmdLicence = commitMeta["licence"]
+		pushCmdForce = restoreCmdForce
+
+		result := pushDispatch(blobPath, commitMeta["author"], commitMeta["email"])
+		if result.err != nil {
+			return errors.New(fmt.Sprintf("Restoring commit '%s' failed: %v", c.ID, result.err))
+		}
+	}
+	return nil
+}
+
+// readDumpedCommitMeta reads back the commit metadata a prior "dio dump" wrote to
+// <dir>/commits/<db>-<id>.json.
+func readDumpedCommitMeta(dir, dbName, commitID string) (map[string]string, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "commits", dbName+"-"+commitID+".json"))
+	if err != nil {
+		return nil, err
+	}
+	meta := map[string]string{}
+	if err = json.Unmarshal(raw, &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+// canonicalCommitString's field order and separators are part of the signed format - changing them would
+// invalidate every previously generated signature, so pin both down explicitly here.
+func TestCanonicalCommitString(t *testing.T) {
+	got := canonicalCommitString("alice", "alice@example.org", "initial import", "2020-01-02T03:04:05Z",
+		"parent123", "deadbeef", "master", "cc0")
+	want := strings.Join([]string{
+		"author:alice",
+		"email:alice@example.org",
+		"message:initial import",
+		"timestamp:2020-01-02T03:04:05Z",
+		"parent:parent123",
+		"sha256:deadbeef",
+		"branch:master",
+		"licence:cc0",
+	}, "\n")
+	if got != want {
+		t.Fatalf("canonical string changed format:\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+// Two commits differing only in a single field value (here the licence, where one value is a prefix of the
+// other) must still produce distinct canonical strings.
+func TestCanonicalCommitStringDistinctFields(t *testing.T) {
+	a := canonicalCommitString("alice", "a@example.org", "msg", "t", "p", "sha", "master", "cc0")
+	b := canonicalCommitString("alice", "a@example.org", "msg", "t", "p", "sha", "master", "cc0-by")
+	if a == b {
+		t.Fatal("expected differing licence to produce a different canonical string")
+	}
+}
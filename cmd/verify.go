@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var verifyCmdKeyring string
+
+// Walks a database's locally recorded commit signatures and reports whether each one still checks out.
+var verifyCmd = &cobra.Command{
+	Use:   "verify [database name]",
+	Short: "Verify the GPG signatures of a database's local commit history",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("Exactly one database name must be given")
+		}
+		db := args[0]
+
+		dir := filepath.Join(".dio", db, "commits")
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return errors.New(fmt.Sprintf("No local commit signatures found for '%s'", db))
+			}
+			return err
+		}
+
+		keyring := verifyCmdKeyring
+		if keyring == "" {
+			keyring = viper.GetString("verify.keyring")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "COMMIT\tRESULT")
+		var numInvalid int
+		seen := make(map[string]bool)
+		for _, e := range entries {
+			if !strings.HasSuffix(e.Name(), ".sig") {
+				continue
+			}
+			commitID := strings.TrimSuffix(e.Name(), ".sig")
+			if seen[commitID] {
+				continue
+			}
+			seen[commitID] = true
+
+			meta, sig, ok, err := loadCommitSignature(db, commitID)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				fmt.Fprintf(w, "%s\tno commit metadata recorded, skipped\n", commitID)
+				continue
+			}
+
+			canonical := canonicalCommitString(meta.Author, meta.Email, meta.Message, meta.Timestamp,
+				meta.Parent, meta.SHA256, meta.Branch, meta.Licence)
+			valid, err := gpgVerifyDetached([]byte(canonical), []byte(sig), keyring)
+			if err != nil {
+				return err
+			}
+			if !valid {
+				numInvalid++
+				fmt.Fprintf(w, "%s\tINVALID\n", commitID)
+				continue
+			}
+			fmt.Fprintf(w, "%s\tvalid\n", commitID)
+		}
+		w.Flush()
+
+		if numInvalid > 0 {
+			return errors.New(fmt.Sprintf("%d commit signature(s) failed verification", numInvalid))
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().StringVar(&verifyCmdKeyring, "keyring", "",
+		"GPG keyring to verify signatures against (defaults to 'verify.keyring' in config, or the user's own keyring)")
+}